@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// ErrAdmissionDenied is returned by RunStart when a lambda could not be
+// admitted before its queue timeout expired, either because its own
+// per-handler cap or the worker's global cap was saturated. The worker's
+// HTTP layer should translate this into a 429.
+var ErrAdmissionDenied = fmt.Errorf("admission denied: too many concurrent runners")
+
+// defaultAdmissionQueueTimeout is used in place of a zero-valued
+// Admission_queue_timeout when a concurrency cap is configured. Without
+// it, setting a cap but leaving the timeout at its zero default would
+// make acquire block forever instead of eventually returning
+// ErrAdmissionDenied. It's kept comfortably above the 45s a cold start
+// is allowed to take in RunStart's wait-for-sock loop, so a request
+// queued behind a normal (if slow) cold start isn't spuriously denied.
+const defaultAdmissionQueueTimeout = 60 * time.Second
+
+// admissionControl bounds how many lambda runs can be in flight at once,
+// both per-handler and worker-wide, so one hot lambda can't starve every
+// other handler of sandbox resources. It's a pair of semaphores
+// implemented as buffered channels: acquiring a slot is sending a token,
+// releasing is receiving one back. A zero-valued limit means "no cap".
+type admissionControl struct {
+	global  chan struct{}
+	timeout time.Duration
+}
+
+func newAdmissionControl(opts *config.Config) *admissionControl {
+	var global chan struct{}
+	if opts.Max_runners_global > 0 {
+		global = make(chan struct{}, opts.Max_runners_global)
+	}
+
+	timeout := time.Duration(opts.Admission_queue_timeout) * time.Second
+	if timeout <= 0 && (opts.Max_runners_global > 0 || opts.Max_runners_per_handler > 0) {
+		timeout = defaultAdmissionQueueTimeout
+	}
+
+	return &admissionControl{
+		global:  global,
+		timeout: timeout,
+	}
+}
+
+// acquire blocks until both the handler's per-handler slot and the
+// HandlerSet's global slot are available, or the admission queue timeout
+// elapses, whichever comes first.
+func (a *admissionControl) acquire(ctx context.Context, perHandler chan struct{}) error {
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+
+	if perHandler != nil {
+		select {
+		case perHandler <- struct{}{}:
+		case <-ctx.Done():
+			return ErrAdmissionDenied
+		}
+	}
+
+	if a.global != nil {
+		select {
+		case a.global <- struct{}{}:
+		case <-ctx.Done():
+			if perHandler != nil {
+				<-perHandler
+			}
+			return ErrAdmissionDenied
+		}
+	}
+
+	return nil
+}
+
+func (a *admissionControl) release(perHandler chan struct{}) {
+	if a.global != nil {
+		<-a.global
+	}
+	if perHandler != nil {
+		<-perHandler
+	}
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+func TestNewAdmissionControlDefaultsTimeoutWhenCapped(t *testing.T) {
+	a := newAdmissionControl(&config.Config{Max_runners_per_handler: 1})
+	if a.timeout != defaultAdmissionQueueTimeout {
+		t.Fatalf("got timeout %v, want default %v", a.timeout, defaultAdmissionQueueTimeout)
+	}
+}
+
+func TestNewAdmissionControlNoTimeoutWithoutCap(t *testing.T) {
+	a := newAdmissionControl(&config.Config{})
+	if a.timeout != 0 {
+		t.Fatalf("got timeout %v, want 0 (no cap, no timeout)", a.timeout)
+	}
+}
+
+func TestNewAdmissionControlHonorsExplicitTimeout(t *testing.T) {
+	a := newAdmissionControl(&config.Config{Max_runners_per_handler: 1, Admission_queue_timeout: 5})
+	if a.timeout != 5*time.Second {
+		t.Fatalf("got timeout %v, want 5s", a.timeout)
+	}
+}
+
+func TestAcquireDeniesInsteadOfBlockingForeverWhenCapExhausted(t *testing.T) {
+	a := newAdmissionControl(&config.Config{Max_runners_per_handler: 1, Admission_queue_timeout: 1})
+	perHandler := make(chan struct{}, 1)
+
+	if err := a.acquire(context.Background(), perHandler); err != nil {
+		t.Fatalf("first acquire: unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- a.acquire(context.Background(), perHandler) }()
+
+	select {
+	case err := <-done:
+		if err != ErrAdmissionDenied {
+			t.Fatalf("got err %v, want ErrAdmissionDenied", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire blocked well past its configured admission queue timeout")
+	}
+}
@@ -3,15 +3,18 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/open-lambda/open-lambda/worker/config"
 	"github.com/open-lambda/open-lambda/worker/handler/state"
 	"github.com/open-lambda/open-lambda/worker/pool-manager/policy"
@@ -33,9 +36,13 @@ type HandlerSet struct {
 	lru       *HandlerLRU
 	workerDir string
 	pipMirror string
-	hhits     *int64
-	ihits     *int64
-	misses    *int64
+	logger    hclog.Logger
+	metrics   *metrics
+	admission *admissionControl
+	prewarm   *prewarmPool
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	draining  bool
 }
 
 // Handler handles requests to run a lambda on a worker server. It handles
@@ -55,10 +62,22 @@ type Handler struct {
 	sandboxDir string
 	fs         *policy.ForkServer
 	usage      int
+	runStart   time.Time
+	sem        chan struct{}
+	logger     hclog.Logger
 }
 
-// NewHandlerSet creates an empty HandlerSet
-func NewHandlerSet(opts *config.Config) (handlerSet *HandlerSet, err error) {
+// NewHandlerSet creates an empty HandlerSet. reg is the Prometheus
+// registry metrics are published to; it may be nil (e.g. in tests, or
+// when opts.Enable_metrics is false), in which case metrics are computed
+// but never exposed.
+func NewHandlerSet(opts *config.Config, reg prometheus.Registerer) (handlerSet *HandlerSet, err error) {
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:       "handler",
+		Level:      hclog.LevelFromString(opts.Log_level),
+		JSONFormat: opts.Log_json,
+	})
+
 	rm, err := registry.InitRegistryManager(opts)
 	if err != nil {
 		return nil, err
@@ -74,36 +93,73 @@ func NewHandlerSet(opts *config.Config) (handlerSet *HandlerSet, err error) {
 		return nil, err
 	}
 
-	var hhits int64 = 0
-	var ihits int64 = 0
-	var misses int64 = 0
+	if !opts.Enable_metrics {
+		reg = nil
+	}
+
 	handlers := make(map[string]*Handler)
 	handlerSet = &HandlerSet{
 		handlers:  handlers,
 		regMgr:    rm,
 		sbFactory: sf,
 		poolMgr:   pm,
+		config:    opts,
 		workerDir: opts.Worker_dir,
 		pipMirror: opts.Pip_mirror,
-		hhits:     &hhits,
-		ihits:     &ihits,
-		misses:    &misses,
+		logger:    logger,
+		metrics:   newMetrics(reg),
+		admission: newAdmissionControl(opts),
+		stopCh:    make(chan struct{}),
 	}
 
 	handlerSet.lru = NewHandlerLRU(handlerSet, opts.Handler_cache_size) //kb
 
+	if reg != nil {
+		reg.MustRegister(&handlersInStateCollector{hset: handlerSet})
+	}
+
+	if opts.Prewarm_pool_size > 0 {
+		handlerSet.prewarm = newPrewarmPool(handlerSet, opts.Prewarm_pool_size)
+		handlerSet.wg.Add(1)
+		go withRecover(logger, "prewarm pool", func() {
+			defer handlerSet.wg.Done()
+			handlerSet.prewarm.run()
+		})
+	}
+
 	if pm != nil {
-		go handlerSet.killOrphans()
+		handlerSet.wg.Add(1)
+		go withRecover(logger, "killOrphans", func() {
+			defer handlerSet.wg.Done()
+			handlerSet.killOrphans()
+		})
 	}
 
 	return handlerSet, nil
 }
 
-// Get always returns a Handler, creating one if necessarily.
+// withRecover runs fn, logging and recovering from any panic instead of
+// letting it crash the whole worker process. A bug in one sandbox
+// driver's background goroutine shouldn't take down every other lambda.
+func withRecover(logger hclog.Logger, name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("recovered from panic", "goroutine", name, "panic", r)
+		}
+	}()
+	fn()
+}
+
+// Get always returns a Handler, creating one if necessary. It returns nil
+// if the HandlerSet is shutting down and no longer accepting new work.
 func (h *HandlerSet) Get(name string) *Handler {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
+	if h.draining {
+		return nil
+	}
+
 	handler := h.handlers[name]
 	if handler == nil {
 		sandboxDir := path.Join(h.workerDir, "handlers", name, "sandbox")
@@ -115,6 +171,10 @@ func (h *HandlerSet) Get(name string) *Handler {
 			pkgs:       []string{},
 			sandboxDir: sandboxDir,
 		}
+		if h.config != nil && h.config.Max_runners_per_handler > 0 {
+			handler.sem = make(chan struct{}, h.config.Max_runners_per_handler)
+		}
+		handler.logger = h.logger.Named("lambda").With("lambda", name)
 		h.handlers[name] = handler
 	}
 
@@ -123,28 +183,121 @@ func (h *HandlerSet) Get(name string) *Handler {
 
 func (h *HandlerSet) killOrphans() {
 	for {
-		time.Sleep(5 * time.Millisecond)
-		h.mutex.Lock()
-		defer h.mutex.Unlock()
+		select {
+		case <-h.stopCh:
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
 
+		h.mutex.Lock()
 		for _, handler := range h.handlers {
 			handler.mutex.Lock()
 			if handler.sandbox != nil && handler.fs == nil {
-				h.mutex.Lock()
-				h.handlers[handler.name] = nil
-				h.mutex.Unlock()
+				delete(h.handlers, handler.name)
 
 				for handler.runners > 0 {
 					handler.mutex.Unlock()
 					time.Sleep(1 * time.Millisecond)
 					handler.mutex.Lock()
 				}
-				go handler.nuke()
+				h.wg.Add(1)
+				go withRecover(h.logger, "sandbox nuke", func() {
+					defer h.wg.Done()
+					handler.nuke()
+				})
 			}
 			handler.mutex.Unlock()
 		}
+		h.mutex.Unlock()
+	}
+}
+
+// Shutdown stops the HandlerSet from accepting new work and tears down
+// every handler's sandbox, waiting (bounded by ctx) for in-flight runs to
+// drain first. It is meant to be called once, at worker exit.
+//
+// Handlers are drained concurrently and independently: one handler
+// hitting its drain deadline does not stop the rest from being drained,
+// and it never skips the prewarm pool, killOrphans goroutine, or pool
+// manager teardown that follow. Errors from individual handlers are
+// collected and returned together rather than aborting on the first one.
+func (h *HandlerSet) Shutdown(ctx context.Context) error {
+	h.mutex.Lock()
+	h.draining = true
+	handlers := make([]*Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, handler := range handlers {
+		wg.Add(1)
+		go func(handler *Handler) {
+			defer wg.Done()
+			if err := handler.drain(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", handler.name, err))
+				mu.Unlock()
+			}
+		}(handler)
+	}
+	wg.Wait()
 
+	if h.prewarm != nil {
+		h.prewarm.Close()
 	}
+
+	close(h.stopCh)
+	h.wg.Wait()
+
+	if h.poolMgr != nil {
+		h.poolMgr.Cleanup()
+	}
+
+	return joinErrors(errs)
+}
+
+// joinErrors combines zero or more drain errors into a single error, or
+// nil if errs is empty.
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("shutdown: %d handler(s) failed to drain: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// drain waits for a handler's in-flight runners to finish, bounded by
+// ctx, then tears down its sandbox.
+func (h *Handler) drain(ctx context.Context) error {
+	for {
+		h.mutex.Lock()
+		runners := h.runners
+		h.mutex.Unlock()
+
+		if runners == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	if sandbox := h.sandbox; sandbox != nil {
+		sandbox.Unpause()
+		sandbox.Stop()
+		return sandbox.Remove()
+	}
+	return nil
 }
 
 // Dump prints the name and state of the Handlers currently in the HandlerSet.
@@ -152,9 +305,8 @@ func (h *HandlerSet) Dump() {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	log.Printf("HANDLERS:\n")
 	for k, v := range h.handlers {
-		log.Printf("> %v: %v\n", k, v.state.String())
+		h.logger.Info("handler", "lambda", k, "state", v.state.String())
 	}
 }
 
@@ -162,6 +314,21 @@ func (h *HandlerSet) Dump() {
 // been pulled, sandbox been created, and sandbox been started. The channel of
 // the sandbox of this lambda is returned.
 func (h *Handler) RunStart() (ch *sb.SandboxChannel, err error) {
+	h.hset.metrics.admissionQueueDepth.WithLabelValues(h.name).Inc()
+	admitErr := h.hset.admission.acquire(context.Background(), h.sem)
+	h.hset.metrics.admissionQueueDepth.WithLabelValues(h.name).Dec()
+	if admitErr != nil {
+		h.hset.metrics.admissionRejections.WithLabelValues(h.name).Inc()
+		return nil, admitErr
+	}
+	// if RunStart fails after this point, no RunFinish will ever come to
+	// release our admission slot, so we must release it ourselves.
+	defer func() {
+		if err != nil {
+			h.hset.admission.release(h.sem)
+		}
+	}()
+
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
@@ -184,17 +351,33 @@ func (h *Handler) RunStart() (ch *sb.SandboxChannel, err error) {
 			return nil, err
 		}
 
-		sandbox, err := h.hset.sbFactory.Create(h.codeDir, h.sandboxDir, h.hset.pipMirror)
-		if err != nil {
-			return nil, err
+		var sandbox sb.Sandbox
+		fromPool := false
+		if h.hset.prewarm != nil {
+			if pooled, ok := h.hset.prewarm.Get(h.codeDir); ok {
+				sandbox = pooled
+				fromPool = true
+			}
+		}
+
+		if sandbox == nil {
+			createStart := time.Now()
+			var err error
+			sandbox, err = h.hset.sbFactory.Create(h.codeDir, h.sandboxDir, h.hset.pipMirror)
+			h.hset.metrics.sandboxCreateSeconds.WithLabelValues(h.name).Observe(time.Since(createStart).Seconds())
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		h.sandbox = sandbox
+		var err error
 		if h.state, err = sandbox.State(); err != nil {
 			return nil, err
 		}
 
 		// newly created sandbox could be in any state; let it run
+		startTime := time.Now()
 		if h.state == state.Stopped {
 			if err := sandbox.Start(); err != nil {
 				return nil, err
@@ -204,6 +387,11 @@ func (h *Handler) RunStart() (ch *sb.SandboxChannel, err error) {
 				return nil, err
 			}
 		}
+		h.hset.metrics.sandboxStartSeconds.WithLabelValues(h.name).Observe(time.Since(startTime).Seconds())
+
+		if fromPool {
+			h.hset.metrics.prewarmHits.WithLabelValues(h.name).Inc()
+		}
 
 		hit := false
 		if h.hset.poolMgr != nil {
@@ -218,36 +406,40 @@ func (h *Handler) RunStart() (ch *sb.SandboxChannel, err error) {
 		}
 
 		if hit {
-			atomic.AddInt64(h.hset.ihits, 1)
+			h.hset.metrics.importHits.WithLabelValues(h.name).Inc()
 		} else {
-			atomic.AddInt64(h.hset.misses, 1)
+			h.hset.metrics.cacheMisses.WithLabelValues(h.name).Inc()
 		}
 
 		sockPath := fmt.Sprintf("%s/ol.sock", h.sandboxDir)
 
 		// wait up to 30s for server to initialize
-		start := time.Now()
+		waitStart := time.Now()
 		for ok := true; ok; ok = os.IsNotExist(err) {
 			_, err = os.Stat(sockPath)
-			if time.Since(start).Seconds() > 45 {
+			if time.Since(waitStart).Seconds() > 45 {
 				return nil, errors.New(fmt.Sprintf("handler server failed to initialize after 30s"))
 			}
 		}
+		h.hset.metrics.waitForSockSeconds.WithLabelValues(h.name).Observe(time.Since(waitStart).Seconds())
+		h.logger.Debug("cold start", "lambda", h.name, "from_pool", fromPool, "forkserver_hit", hit)
 
 	} else if h.state == state.Paused { // unpause if paused
-		atomic.AddInt64(h.hset.hhits, 1)
+		h.hset.metrics.handlerHits.WithLabelValues(h.name).Inc()
 		if err := h.sandbox.Unpause(); err != nil {
 			return nil, err
 		}
 		h.hset.lru.Remove(h)
+		h.logger.Debug("handler hit", "lambda", h.name, "was_paused", true)
 	} else {
-		atomic.AddInt64(h.hset.hhits, 1)
+		h.hset.metrics.handlerHits.WithLabelValues(h.name).Inc()
+		h.logger.Debug("handler hit", "lambda", h.name, "was_paused", false)
 	}
 
 	h.state = state.Running
 	h.runners += 1
+	h.runStart = time.Now()
 
-	log.Printf("handler hits: %v, import hits: %v, misses: %v", *h.hset.hhits, *h.hset.ihits, *h.hset.misses)
 	return h.sandbox.Channel()
 }
 
@@ -259,6 +451,11 @@ func (h *Handler) RunFinish() {
 	defer h.mutex.Unlock()
 
 	h.runners -= 1
+	duration := time.Since(h.runStart)
+	h.hset.metrics.runDurationSeconds.WithLabelValues(h.name).Observe(duration.Seconds())
+	h.hset.admission.release(h.sem)
+
+	h.logger.Debug("run finished", "lambda", h.name, "sandbox_id", h.sandboxDir, "state", h.state.String(), "runners", h.runners, "duration_ms", duration.Milliseconds())
 
 	// are we the last?
 	if h.runners == 0 {
@@ -266,7 +463,7 @@ func (h *Handler) RunFinish() {
 			// TODO(tyler): better way to handle this?  If
 			// we can't pause, the handler gets to keep
 			// running for free...
-			log.Printf("Could not pause %v!  Error: %v\n", h.name, err)
+			h.logger.Error("could not pause sandbox", "lambda", h.name, "error", err)
 		}
 		h.state = state.Paused
 		h.hset.lru.Add(h)
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// fakeSandbox is a minimal sb.Sandbox for exercising HandlerSet.Shutdown
+// without a real sandbox backend.
+type fakeSandbox struct {
+	mu      sync.Mutex
+	removed bool
+}
+
+func (f *fakeSandbox) State() (state.HandlerState, error)   { return state.Stopped, nil }
+func (f *fakeSandbox) Channel() (*sb.SandboxChannel, error) { return nil, nil }
+func (f *fakeSandbox) Start() error                         { return nil }
+func (f *fakeSandbox) Stop() error                          { return nil }
+func (f *fakeSandbox) Pause() error                         { return nil }
+func (f *fakeSandbox) Unpause() error                       { return nil }
+func (f *fakeSandbox) Remove() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = true
+	return nil
+}
+
+func (f *fakeSandbox) wasRemoved() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.removed
+}
+
+// TestShutdownDrainsPastStuckHandler verifies that a handler which never
+// finishes draining (e.g. a runner that never calls RunFinish) does not
+// stop other handlers from being drained, nor skip the stopCh/wg/pool
+// manager teardown that follows.
+func TestShutdownDrainsPastStuckHandler(t *testing.T) {
+	stuckSandbox := &fakeSandbox{}
+	okSandbox := &fakeSandbox{}
+
+	hset := &HandlerSet{
+		handlers: map[string]*Handler{
+			"stuck": {name: "stuck", sandbox: stuckSandbox, runners: 1},
+			"ok":    {name: "ok", sandbox: okSandbox, runners: 0},
+		},
+		stopCh: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- hset.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error from the stuck handler, got nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not return; a stuck handler must not block the rest of teardown")
+	}
+
+	if !okSandbox.wasRemoved() {
+		t.Error("healthy handler's sandbox was never removed")
+	}
+	select {
+	case <-hset.stopCh:
+	default:
+		t.Error("stopCh was not closed")
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+// metrics bundles the Prometheus collectors for the handler package. It's
+// always non-nil; when the caller passes a nil Registerer (e.g. in
+// tests, or when metrics are disabled in config) the collectors are
+// simply never registered, so Inc/Observe calls remain safe no-ops as
+// far as scraping is concerned.
+type metrics struct {
+	handlerHits *prometheus.CounterVec
+	importHits  *prometheus.CounterVec
+	cacheMisses *prometheus.CounterVec
+	prewarmHits *prometheus.CounterVec
+
+	sandboxCreateSeconds *prometheus.HistogramVec
+	sandboxStartSeconds  *prometheus.HistogramVec
+	waitForSockSeconds   *prometheus.HistogramVec
+	runDurationSeconds   *prometheus.HistogramVec
+
+	admissionQueueDepth  *prometheus.GaugeVec
+	admissionRejections *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		handlerHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ol_handler_hits_total",
+			Help: "Number of RunStart calls served by the handler's existing (running or paused) sandbox.",
+		}, []string{"lambda"}),
+		importHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ol_import_hits_total",
+			Help: "Number of RunStart calls served by a forkserver import cache hit.",
+		}, []string{"lambda"}),
+		cacheMisses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ol_cache_misses_total",
+			Help: "Number of RunStart calls that required a full cold start.",
+		}, []string{"lambda"}),
+		prewarmHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ol_prewarm_hits_total",
+			Help: "Number of RunStart calls served by a sandbox pulled from the prewarm pool.",
+		}, []string{"lambda"}),
+		sandboxCreateSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ol_sandbox_create_seconds",
+			Help: "Time spent in sbFactory.Create.",
+		}, []string{"lambda"}),
+		sandboxStartSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ol_sandbox_start_seconds",
+			Help: "Time spent starting or unpausing a sandbox.",
+		}, []string{"lambda"}),
+		waitForSockSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ol_lambda_wait_for_sock_seconds",
+			Help: "Time spent waiting for a sandbox's ol.sock to appear.",
+		}, []string{"lambda"}),
+		runDurationSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ol_run_duration_seconds",
+			Help: "Wall-clock time of a RunStart/RunFinish pair.",
+		}, []string{"lambda"}),
+		admissionQueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ol_admission_queue_depth",
+			Help: "Number of RunStart calls currently waiting for an admission slot.",
+		}, []string{"lambda"}),
+		admissionRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ol_admission_rejections_total",
+			Help: "Number of RunStart calls denied after waiting past the admission queue timeout.",
+		}, []string{"lambda"}),
+	}
+
+	if reg != nil {
+		reg.MustRegister(
+			m.handlerHits, m.importHits, m.cacheMisses, m.prewarmHits,
+			m.sandboxCreateSeconds, m.sandboxStartSeconds, m.waitForSockSeconds, m.runDurationSeconds,
+			m.admissionQueueDepth, m.admissionRejections,
+		)
+	}
+
+	return m
+}
+
+// handlersInStateDesc describes ol_handlers_in_state, collected lazily
+// by handlersInStateCollector rather than kept as a plain GaugeVec, so
+// it always reflects HandlerSet.handlers at scrape time instead of
+// whatever it was the last time something happened to recompute it.
+var handlersInStateDesc = prometheus.NewDesc(
+	"ol_handlers_in_state",
+	"Number of handlers currently in each state.",
+	[]string{"state"}, nil,
+)
+
+// handlersInStateCollector is a prometheus.Collector that derives
+// ol_handlers_in_state from the live HandlerSet on every scrape.
+type handlersInStateCollector struct {
+	hset *HandlerSet
+}
+
+func (c *handlersInStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- handlersInStateDesc
+}
+
+func (c *handlersInStateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.hset.mutex.Lock()
+	counts := map[string]float64{}
+	for _, handler := range c.hset.handlers {
+		if handler == nil {
+			continue
+		}
+		counts[handler.state.String()]++
+	}
+	c.hset.mutex.Unlock()
+
+	for _, s := range []string{
+		state.Unitialized.String(), state.Stopped.String(),
+		state.Running.String(), state.Paused.String(),
+	} {
+		ch <- prometheus.MustNewConstMetric(handlersInStateDesc, prometheus.GaugeValue, counts[s], s)
+	}
+}
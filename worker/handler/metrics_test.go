@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+// TestHandlersInStateCollectorReflectsLiveHandlers verifies that the
+// ol_handlers_in_state gauge is derived from HandlerSet.handlers at
+// Collect time, not frozen at whatever it was when something last
+// happened to call Dump().
+func TestHandlersInStateCollectorReflectsLiveHandlers(t *testing.T) {
+	hset := &HandlerSet{
+		handlers: map[string]*Handler{
+			"a": {name: "a", state: state.Paused},
+			"b": {name: "b", state: state.Paused},
+			"c": {name: "c", state: state.Running},
+		},
+	}
+	collector := &handlersInStateCollector{hset: hset}
+
+	counts := collectHandlersInState(t, collector)
+	if counts[state.Paused.String()] != 2 {
+		t.Errorf("got %v paused, want 2", counts[state.Paused.String()])
+	}
+	if counts[state.Running.String()] != 1 {
+		t.Errorf("got %v running, want 1", counts[state.Running.String()])
+	}
+
+	// Mutate the live handler set and collect again: the gauge must
+	// track it without any explicit refresh call.
+	hset.handlers["c"].state = state.Paused
+	counts = collectHandlersInState(t, collector)
+	if counts[state.Paused.String()] != 3 {
+		t.Errorf("got %v paused after mutation, want 3", counts[state.Paused.String()])
+	}
+}
+
+func collectHandlersInState(t *testing.T, collector prometheus.Collector) map[string]float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 16)
+	collector.Collect(ch)
+	close(ch)
+
+	counts := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("failed to write metric: %v", err)
+		}
+		var stateLabel string
+		for _, lp := range pb.GetLabel() {
+			if lp.GetName() == "state" {
+				stateLabel = lp.GetValue()
+			}
+		}
+		counts[stateLabel] = pb.GetGauge().GetValue()
+	}
+	return counts
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// prewarmSpawnBackoff is how long run() waits after a failed spawn
+// before retrying, so a misconfigured sandbox backend (missing runsc
+// binary, bad rootfs, ...) backs off instead of spinning a hot loop.
+const prewarmSpawnBackoff = 1 * time.Second
+
+// prewarmPool maintains a fixed-size buffer of idle, already-started
+// sandboxes running a generic (code-less) rootfs, so a cold RunStart can
+// grab one instead of paying sbFactory.Create's full latency on the
+// request path.
+type prewarmPool struct {
+	hset    *HandlerSet
+	size    int
+	ready   chan sb.Sandbox
+	done    chan struct{}
+	stopped chan struct{}
+	counter int64
+}
+
+func newPrewarmPool(hset *HandlerSet, size int) *prewarmPool {
+	return &prewarmPool{
+		hset:    hset,
+		size:    size,
+		ready:   make(chan sb.Sandbox, size),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// nextSandboxDir returns a fresh, never-reused sandbox directory path for
+// a prewarmed sandbox. It must not be derived from len(p.ready): that
+// depth is reused as sandboxes are concurrently pulled out by Get and
+// refilled by spawn, which would let two live sandboxes collide on the
+// same directory.
+func (p *prewarmPool) nextSandboxDir() string {
+	id := atomic.AddInt64(&p.counter, 1)
+	return path.Join(p.hset.workerDir, "prewarm", fmt.Sprintf("%d", id))
+}
+
+// run keeps the pool topped up until Close is called. It's meant to be
+// started as its own goroutine from NewHandlerSet. stopped is closed
+// once run has returned, so Close can wait for it before declaring the
+// pool fully drained, rather than racing a final spawn into p.ready.
+func (p *prewarmPool) run() {
+	defer close(p.stopped)
+
+	failures := 0
+	for {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		sandbox, err := p.spawn()
+		if err != nil {
+			failures++
+			p.hset.logger.Error("failed to spawn prewarm sandbox", "error", err, "consecutive_failures", failures)
+			select {
+			case <-p.done:
+				return
+			case <-time.After(prewarmSpawnBackoff):
+			}
+			continue
+		}
+		failures = 0
+
+		select {
+		case p.ready <- sandbox:
+		case <-p.done:
+			sandbox.Unpause()
+			sandbox.Stop()
+			sandbox.Remove()
+			return
+		}
+	}
+}
+
+func (p *prewarmPool) spawn() (sb.Sandbox, error) {
+	sandboxDir := p.nextSandboxDir()
+	if err := os.MkdirAll(sandboxDir, 0700); err != nil {
+		return nil, err
+	}
+
+	sandbox, err := p.hset.sbFactory.Create("", sandboxDir, p.hset.pipMirror)
+	if err != nil {
+		return nil, err
+	}
+	if err := sandbox.Start(); err != nil {
+		sandbox.Remove()
+		return nil, err
+	}
+	return sandbox, nil
+}
+
+// Get returns a pre-warmed, already-running sandbox bound to codeDir, or
+// ok=false if the pool is empty and the caller should fall back to
+// sbFactory.Create.
+func (p *prewarmPool) Get(codeDir string) (sandbox sb.Sandbox, ok bool) {
+	select {
+	case sandbox = <-p.ready:
+	default:
+		return nil, false
+	}
+
+	containerSB, isContainer := sandbox.(sb.ContainerSandbox)
+	if !isContainer {
+		sandbox.Unpause()
+		sandbox.Stop()
+		sandbox.Remove()
+		return nil, false
+	}
+
+	if err := containerSB.MountCode(codeDir); err != nil {
+		sandbox.Unpause()
+		sandbox.Stop()
+		sandbox.Remove()
+		return nil, false
+	}
+
+	return sandbox, true
+}
+
+// Close stops the fill goroutine and drains any sandboxes still sitting
+// idle in the pool. It waits for run to actually exit before draining,
+// so a spawn that was in flight when Close was called can't race a
+// single drain pass and leave its sandbox stranded in p.ready forever.
+func (p *prewarmPool) Close() {
+	close(p.done)
+	<-p.stopped
+	for {
+		select {
+		case sandbox := <-p.ready:
+			sandbox.Unpause()
+			sandbox.Stop()
+			sandbox.Remove()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// blockingFactory blocks Create until startSpawn is closed, so tests can
+// control exactly when an in-flight spawn completes relative to Close.
+type blockingFactory struct {
+	startSpawn chan struct{}
+	sandbox    sb.Sandbox
+}
+
+func (f *blockingFactory) Create(codeDir, sandboxDir, pipMirror string) (sb.Sandbox, error) {
+	<-f.startSpawn
+	return f.sandbox, nil
+}
+
+func (f *blockingFactory) Cleanup() {}
+
+func TestPrewarmPoolNextSandboxDirUnique(t *testing.T) {
+	p := newPrewarmPool(&HandlerSet{workerDir: t.TempDir()}, 4)
+
+	const n = 50
+	seen := make(map[string]bool, n)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dir := p.nextSandboxDir()
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[dir] {
+				t.Errorf("sandbox dir %q reused", dir)
+			}
+			seen[dir] = true
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != n {
+		t.Fatalf("got %d unique dirs, want %d", len(seen), n)
+	}
+}
+
+// TestPrewarmPoolCloseWaitsForInFlightSpawn guards against the race
+// where a spawn completing right after Close's one drain pass leaves
+// its sandbox stuck in p.ready forever. Close must not return until
+// run has actually exited and every sandbox it produced has been torn
+// down.
+func TestPrewarmPoolCloseWaitsForInFlightSpawn(t *testing.T) {
+	startSpawn := make(chan struct{})
+	sandbox := &fakeSandbox{}
+	factory := &blockingFactory{startSpawn: startSpawn, sandbox: sandbox}
+
+	hset := &HandlerSet{
+		workerDir: t.TempDir(),
+		sbFactory: factory,
+		logger:    hclog.NewNullLogger(),
+	}
+	p := newPrewarmPool(hset, 1)
+
+	go p.run()
+	time.Sleep(20 * time.Millisecond) // let run() block inside spawn()
+
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+
+	// Let the in-flight spawn finish only after Close has already
+	// closed p.done, racing run()'s send into p.ready.
+	time.Sleep(20 * time.Millisecond)
+	close(startSpawn)
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not return after the in-flight spawn completed")
+	}
+
+	if !sandbox.wasRemoved() {
+		t.Fatal("sandbox spawned while racing Close was never torn down")
+	}
+}
@@ -0,0 +1,126 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+// containerFactory creates Sandboxes backed by a runc container.
+type containerFactory struct {
+	opts *config.Config
+}
+
+func newContainerFactory(opts *config.Config) (*containerFactory, error) {
+	return &containerFactory{opts: opts}, nil
+}
+
+func (cf *containerFactory) Create(codeDir, sandboxDir, pipMirror string) (Sandbox, error) {
+	return &containerSandbox{
+		opts:       cf.opts,
+		sandboxDir: sandboxDir,
+		codeDir:    codeDir,
+		runtime:    "runc",
+	}, nil
+}
+
+func (cf *containerFactory) Cleanup() {}
+
+// containerSandbox is a Sandbox whose container is driven by an OCI
+// runtime binary (runc by default).
+type containerSandbox struct {
+	opts       *config.Config
+	sandboxDir string
+	codeDir    string
+	runtime    string
+	state      state.HandlerState
+}
+
+func (c *containerSandbox) State() (state.HandlerState, error) {
+	return c.state, nil
+}
+
+func (c *containerSandbox) Channel() (*SandboxChannel, error) {
+	return &SandboxChannel{Url: fmt.Sprintf("unix://%s/ol.sock", c.sandboxDir)}, nil
+}
+
+func (c *containerSandbox) Start() error {
+	cmd := exec.Command(c.runtime, "start", c.sandboxDir)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	c.state = state.Running
+	return nil
+}
+
+func (c *containerSandbox) Stop() error {
+	cmd := exec.Command(c.runtime, "kill", c.sandboxDir)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	c.state = state.Stopped
+	return nil
+}
+
+func (c *containerSandbox) Pause() error {
+	cmd := exec.Command(c.runtime, "pause", c.sandboxDir)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	c.state = state.Paused
+	return nil
+}
+
+func (c *containerSandbox) Unpause() error {
+	cmd := exec.Command(c.runtime, "resume", c.sandboxDir)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	c.state = state.Running
+	return nil
+}
+
+func (c *containerSandbox) Remove() error {
+	cmd := exec.Command(c.runtime, "delete", "-f", c.sandboxDir)
+	return cmd.Run()
+}
+
+func (c *containerSandbox) RootDir() string {
+	return c.sandboxDir
+}
+
+func (c *containerSandbox) MountCode(codeDir string) error {
+	c.codeDir = codeDir
+	return exec.Command("mount", "--bind", codeDir, fmt.Sprintf("%s/code", c.sandboxDir)).Run()
+}
+
+func (c *containerSandbox) Pid() (string, error) {
+	out, err := exec.Command(c.runtime, "state", c.sandboxDir).Output()
+	if err != nil {
+		return "", err
+	}
+	return parseRuntimeStatePid(out)
+}
+
+// runtimeState is the subset of `runc/runsc state <id>` JSON output we
+// care about.
+type runtimeState struct {
+	Pid int `json:"pid"`
+}
+
+// parseRuntimeStatePid extracts the init process pid from the JSON blob
+// printed by `runc/runsc state <id>`.
+func parseRuntimeStatePid(out []byte) (string, error) {
+	var st runtimeState
+	if err := json.Unmarshal(out, &st); err != nil {
+		return "", fmt.Errorf("failed to parse runtime state output: %v", err)
+	}
+	if st.Pid <= 0 {
+		return "", fmt.Errorf("runtime state output did not contain a pid: %s", string(out))
+	}
+	return strconv.Itoa(st.Pid), nil
+}
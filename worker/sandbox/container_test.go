@@ -0,0 +1,25 @@
+package sandbox
+
+import "testing"
+
+func TestParseRuntimeStatePid(t *testing.T) {
+	pid, err := parseRuntimeStatePid([]byte(`{"id":"abc","pid":4242,"status":"running"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pid != "4242" {
+		t.Fatalf("got pid %q, want %q", pid, "4242")
+	}
+}
+
+func TestParseRuntimeStatePidMissing(t *testing.T) {
+	if _, err := parseRuntimeStatePid([]byte(`{"id":"abc","status":"stopped"}`)); err == nil {
+		t.Fatal("expected error for missing pid, got nil")
+	}
+}
+
+func TestParseRuntimeStatePidMalformed(t *testing.T) {
+	if _, err := parseRuntimeStatePid([]byte(`not json`)); err == nil {
+		t.Fatal("expected error for malformed state output, got nil")
+	}
+}
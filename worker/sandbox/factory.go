@@ -0,0 +1,20 @@
+package sandbox
+
+import (
+	"fmt"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// InitSandboxFactory returns a SandboxFactory for the backend selected by
+// opts.Sandbox (defaults to the existing runc-based container backend).
+func InitSandboxFactory(opts *config.Config) (SandboxFactory, error) {
+	switch opts.Sandbox {
+	case "", "container":
+		return newContainerFactory(opts)
+	case "gvisor":
+		return newGVisorFactory(opts)
+	default:
+		return nil, fmt.Errorf("unknown sandbox backend %q", opts.Sandbox)
+	}
+}
@@ -0,0 +1,69 @@
+// Package sandbox defines the interfaces worker code uses to manage the
+// sandboxes (containers) that host lambda code, independent of the
+// underlying isolation technology (runc, gVisor, etc).
+package sandbox
+
+import (
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+// SandboxChannel is the two-way channel a Handler uses to talk to the
+// forkserver or language runtime running inside a Sandbox.
+type SandboxChannel struct {
+	Url       string
+	TransPort string
+}
+
+// Sandbox is a lambda execution environment that supports the Start,
+// Stop, Pause, Unpause, and Remove operations shared by every isolation
+// backend.
+type Sandbox interface {
+	// State returns the current state of the sandbox.
+	State() (state.HandlerState, error)
+
+	// Channel returns the channel used to communicate with the sandbox.
+	Channel() (*SandboxChannel, error)
+
+	// Start starts a stopped sandbox.
+	Start() error
+
+	// Stop stops a sandbox, killing the processes inside it.
+	Stop() error
+
+	// Pause freezes a sandbox without killing it.
+	Pause() error
+
+	// Unpause unfreezes a paused sandbox.
+	Unpause() error
+
+	// Remove removes all resources used by the sandbox.
+	Remove() error
+}
+
+// ContainerSandbox is a Sandbox that is additionally backed by a
+// container, allowing the pool manager to provision a fork server
+// directly inside it.
+type ContainerSandbox interface {
+	Sandbox
+
+	// RootDir returns the path to the sandbox's root filesystem.
+	RootDir() string
+
+	// Pid returns the pid of the sandbox's init process.
+	Pid() (string, error)
+
+	// MountCode bind-mounts codeDir into the sandbox, so a sandbox
+	// created generically (e.g. by the pre-warm pool) can be handed a
+	// lambda's code after the fact.
+	MountCode(codeDir string) error
+}
+
+// SandboxFactory creates Sandboxes.
+type SandboxFactory interface {
+	// Create creates a new, unstarted sandbox rooted at sandboxDir that
+	// will run the lambda code found in codeDir.
+	Create(codeDir, sandboxDir, pipMirror string) (sandbox Sandbox, err error)
+
+	// Cleanup releases any resources held by the factory itself.
+	Cleanup()
+}